@@ -6,7 +6,6 @@ package merkleTree
 
 import (
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/offchainlabs/arbstate/arbos/storage"
 	"github.com/offchainlabs/arbstate/arbos/util"
 )
@@ -16,6 +15,21 @@ type MerkleAccumulator struct {
 	size           uint64
 	numPartials    uint64
 	partials       []*common.Hash
+	scheme         HashScheme
+}
+
+// EventForTreeBuilding records the hash of a complete subtree that existed,
+// even if only momentarily, at a given level while appending the leaf at
+// leafNum. Append reports one of these for every level the new leaf's carry
+// touches, not just the one it finally settles at, so that a subtree
+// completed and then immediately folded into a higher level is still
+// recoverable later. Collecting them in order over the life of an
+// accumulator is enough to replay the tree's construction, and to recover
+// any sibling hash it ever had, without storing the tree in full.
+type EventForTreeBuilding struct {
+	level   uint64
+	leafNum uint64
+	hash    common.Hash
 }
 
 func InitializeMerkleAccumulator(sto *storage.Storage) {
@@ -23,13 +37,39 @@ func InitializeMerkleAccumulator(sto *storage.Storage) {
 }
 
 func OpenMerkleAccumulator(sto *storage.Storage) *MerkleAccumulator {
-	size := sto.GetByInt64(0).Big().Uint64()
+	size, scheme := decodeSizeAndScheme(sto.GetByInt64(0))
 	numPartials := sto.GetByInt64(1).Big().Uint64()
-	return &MerkleAccumulator{sto, size, numPartials, make([]*common.Hash, numPartials)}
+	return &MerkleAccumulator{sto, size, numPartials, make([]*common.Hash, numPartials), scheme}
+}
+
+// OpenMerkleAccumulatorWithScheme opens the accumulator at sto, tagging its
+// metadata slot with scheme if it isn't already tagged. A nonzero slot 0
+// counts as tagged, and the accumulator then keeps the scheme stored there.
+// Because the scheme is persisted alongside size, a later plain
+// OpenMerkleAccumulator call recovers the same scheme automatically, even if
+// this binary's DefaultHashScheme has since changed.
+func OpenMerkleAccumulatorWithScheme(sto *storage.Storage, scheme HashScheme) *MerkleAccumulator {
+	slot := sto.GetByInt64(0)
+	size, existing := decodeSizeAndScheme(slot)
+	if slot != (common.Hash{}) {
+		scheme = existing
+	} else {
+		sto.SetByInt64(0, encodeSizeAndScheme(size, scheme))
+	}
+	numPartials := sto.GetByInt64(1).Big().Uint64()
+	return &MerkleAccumulator{sto, size, numPartials, make([]*common.Hash, numPartials), scheme}
 }
 
 func NewNonpersistentMerkleAccumulator() *MerkleAccumulator {
-	return &MerkleAccumulator{nil, 0, 0, make([]*common.Hash, 0)}
+	return &MerkleAccumulator{nil, 0, 0, make([]*common.Hash, 0), DefaultHashScheme}
+}
+
+// NewNonpersistentMerkleAccumulatorWithScheme is the nonpersistent
+// counterpart to OpenMerkleAccumulatorWithScheme, for light clients or
+// off-chain tooling that want to replay a tree built with a non-default
+// scheme without ever touching storage.
+func NewNonpersistentMerkleAccumulatorWithScheme(scheme HashScheme) *MerkleAccumulator {
+	return &MerkleAccumulator{nil, 0, 0, make([]*common.Hash, 0), scheme}
 }
 
 func (acc *MerkleAccumulator) getPartial(level uint64) *common.Hash {
@@ -60,26 +100,33 @@ func (acc *MerkleAccumulator) setPartial(level uint64, val *common.Hash) {
 	}
 }
 
-func (acc *MerkleAccumulator) Append(itemHash common.Hash) *EventForTreeBuilding {
+// Append hashes itemHash in as a new rightmost leaf and returns one
+// EventForTreeBuilding for every level the new leaf's carry passes through,
+// in level order: every complete subtree that forms along the way, whether
+// it settles into a partial or is immediately folded into the next level
+// up. Returning only the final one would silently discard the hashes
+// ProveMembership and BuildPartialProof need to descend inside a block
+// whose lower levels were folded away the instant they completed.
+func (acc *MerkleAccumulator) Append(itemHash common.Hash) []*EventForTreeBuilding {
 	acc.size++
 	if acc.backingStorage != nil {
-		acc.backingStorage.SetByInt64(0, util.IntToHash(int64(acc.size)))
+		acc.backingStorage.SetByInt64(0, encodeSizeAndScheme(acc.size, acc.scheme))
 	}
+	var events []*EventForTreeBuilding
 	level := uint64(0)
-	soFar := itemHash.Bytes()
+	soFar := hashLeaf(acc.scheme, itemHash)
 	for {
+		events = append(events, &EventForTreeBuilding{level, acc.size - 1, soFar})
 		if level == acc.numPartials {
-			h := common.BytesToHash(soFar)
-			acc.setPartial(level, &h)
-			return &EventForTreeBuilding{level, acc.size - 1, h}
+			acc.setPartial(level, &soFar)
+			return events
 		}
 		thisLevel := acc.getPartial(level)
 		if *thisLevel == (common.Hash{}) {
-			h := common.BytesToHash(soFar)
-			acc.setPartial(level, &h)
-			return &EventForTreeBuilding{level, acc.size - 1, h}
+			acc.setPartial(level, &soFar)
+			return events
 		}
-		soFar = crypto.Keccak256(thisLevel.Bytes(), soFar)
+		soFar = hashInternal(acc.scheme, *thisLevel, soFar)
 		h := common.Hash{}
 		acc.setPartial(level, &h)
 		level += 1
@@ -106,13 +153,13 @@ func (acc *MerkleAccumulator) Root() common.Hash {
 				capacityInHash = capacity
 			} else {
 				for capacityInHash < capacity {
-					h := crypto.Keccak256Hash(hashSoFar.Bytes(), make([]byte, 32))
+					h := hashInternal(acc.scheme, *hashSoFar, common.Hash{})
 					hashSoFar = &h
 					capacityInHash *= 2
 				}
-				h := crypto.Keccak256Hash(partial.Bytes(), hashSoFar.Bytes())
+				h := hashInternal(acc.scheme, *partial, *hashSoFar)
 				hashSoFar = &h
-				capacityInHash = 2*capacity
+				capacityInHash = 2 * capacity
 			}
 		}
 		capacity *= 2
@@ -151,7 +198,16 @@ func (acc *MerkleAccumulator) ToMerkleTree() MerkleTree {
 }
 
 func NewNonPersistentMerkleAccumulatorFromEvents(events []EventForTreeBuilding) *MerkleAccumulator {
-	acc := NewNonpersistentMerkleAccumulator()
+	return NewNonPersistentMerkleAccumulatorFromEventsWithScheme(events, DefaultHashScheme)
+}
+
+// NewNonPersistentMerkleAccumulatorFromEventsWithScheme is
+// NewNonPersistentMerkleAccumulatorFromEvents for a tree that was built with
+// a non-default HashScheme. The events themselves already carry hashes
+// produced under that scheme; the scheme only needs to be recorded so that
+// Root and ToMerkleTree keep combining them the same way Append did.
+func NewNonPersistentMerkleAccumulatorFromEventsWithScheme(events []EventForTreeBuilding, scheme HashScheme) *MerkleAccumulator {
+	acc := NewNonpersistentMerkleAccumulatorWithScheme(scheme)
 	acc.numPartials = uint64(len(events))
 	acc.partials = make([]*common.Hash, len(events))
 	zero := common.Hash{}
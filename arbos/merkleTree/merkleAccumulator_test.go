@@ -0,0 +1,148 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// buildMixedTree appends n leaves to a fresh nonpersistent accumulator,
+// alternating between single Append calls and AppendMany batches of
+// growing size, and returns every event produced along the way together
+// with the leaf hashes used, in leaf order. Mixing both call styles is
+// what exercises the fast and fallback paths of AppendMany's chunking
+// against each other and against plain Append.
+func buildMixedTree(n int) (*MerkleAccumulator, []EventForTreeBuilding, []common.Hash) {
+	acc := NewNonpersistentMerkleAccumulator()
+	var events []EventForTreeBuilding
+	var leaves []common.Hash
+	batch := 1
+	for i := 0; i < n; batch++ {
+		size := batch
+		if i+size > n {
+			size = n - i
+		}
+		if batch%3 == 0 && size > 1 {
+			items := make([]common.Hash, size)
+			for j := range items {
+				items[j] = common.BigToHash(big.NewInt(int64(i + j)))
+			}
+			leaves = append(leaves, items...)
+			for _, e := range acc.AppendMany(items) {
+				events = append(events, *e)
+			}
+		} else {
+			item := common.BigToHash(big.NewInt(int64(i)))
+			leaves = append(leaves, item)
+			for _, e := range acc.Append(item) {
+				events = append(events, *e)
+			}
+			size = 1
+		}
+		i += size
+	}
+	return acc, events, leaves
+}
+
+// TestAccumulatorEventLogIsComplete checks, for every size a mixed
+// Append/AppendMany history can produce, that the resulting event log is
+// rich enough for every proof and replay API to agree with Root().
+func TestAccumulatorEventLogIsComplete(t *testing.T) {
+	for n := 1; n <= 40; n++ {
+		acc, events, leaves := buildMixedTree(n)
+		root := acc.Root()
+
+		for leaf := 0; leaf < n; leaf++ {
+			proof, err := acc.ProveMembership(uint64(leaf), events)
+			if err != nil {
+				t.Fatalf("size %d: ProveMembership(%d): %v", n, leaf, err)
+			}
+			if !VerifyMembership(leaves[leaf], uint64(leaf), uint64(n), root, proof, acc.Scheme()) {
+				t.Fatalf("size %d: VerifyMembership(%d) rejected a valid proof", n, leaf)
+			}
+		}
+
+		assertPartialProof := func(indices []uint64) {
+			t.Helper()
+			partial, err := acc.BuildPartialProof(indices, events)
+			if err != nil {
+				t.Fatalf("size %d: BuildPartialProof(%v): %v", n, indices, err)
+			}
+			matchedIndices, matchedHashes, err := partial.VerifyAndExtract(root)
+			if err != nil {
+				t.Fatalf("size %d: VerifyAndExtract(%v): %v", n, indices, err)
+			}
+			if len(matchedIndices) != len(indices) {
+				t.Fatalf("size %d: BuildPartialProof(%v) matched %d leaves, want %d", n, indices, len(matchedIndices), len(indices))
+			}
+			for k, idx := range matchedIndices {
+				if matchedHashes[k] != hashLeaf(acc.Scheme(), leaves[idx]) {
+					t.Fatalf("size %d: matched leaf %d has the wrong hash", n, idx)
+				}
+			}
+		}
+		all := make([]uint64, n)
+		for leaf := range all {
+			all[leaf] = uint64(leaf)
+		}
+		assertPartialProof(all)
+		assertPartialProof([]uint64{0, uint64(n - 1)})
+
+		for size := uint64(1); size <= uint64(n); size++ {
+			got, err := acc.RootAt(size, events)
+			if err != nil {
+				t.Fatalf("size %d: RootAt(%d): %v", n, size, err)
+			}
+			want := NewNonpersistentMerkleAccumulatorWithScheme(acc.Scheme())
+			for _, leaf := range leaves[:size] {
+				want.Append(leaf)
+			}
+			if wantRoot := want.Root(); got != wantRoot {
+				t.Fatalf("size %d: RootAt(%d) = %v, want %v", n, size, got, wantRoot)
+			}
+		}
+
+		for level := uint64(0); (uint64(1) << level) <= uint64(n); level++ {
+			span := uint64(1) << level
+			for index := uint64(0); (index+1)*span <= uint64(n); index++ {
+				start := index * span
+				sub := NewNonpersistentMerkleAccumulatorWithScheme(acc.Scheme())
+				for _, leaf := range leaves[start : start+span] {
+					sub.Append(leaf)
+				}
+				want := sub.Root()
+				got, ok := SubtreeRoot(level, index, events)
+				if !ok {
+					t.Fatalf("size %d: SubtreeRoot(%d, %d) not found in event log", n, level, index)
+				}
+				if got != want {
+					t.Fatalf("size %d: SubtreeRoot(%d, %d) = %v, want %v", n, level, index, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	acc, _, _ := buildMixedTree(25)
+	root := acc.Root()
+
+	snap, err := acc.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := Verify(snap, root); err != nil {
+		t.Fatalf("Verify of a genuine snapshot failed: %v", err)
+	}
+
+	wrongRoot := root
+	wrongRoot[0] ^= 1
+	if err := Verify(snap, wrongRoot); err == nil {
+		t.Fatalf("Verify accepted a snapshot against the wrong root")
+	}
+}
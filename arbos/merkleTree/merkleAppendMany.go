@@ -0,0 +1,166 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"math/bits"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/offchainlabs/arbstate/arbos/util"
+)
+
+// PreviousPowerOfTwo returns the largest power of two less than or equal to
+// n, or 0 if n is 0.
+func PreviousPowerOfTwo(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	return uint64(1) << (bits.Len64(n) - 1)
+}
+
+// AppendMany appends items to the accumulator in bulk, for bulk ingestion
+// such as replaying a large event log into a NonpersistentMerkleAccumulator
+// or importing a batch of outbox messages. It returns the same
+// EventForTreeBuilding values, in the same order, that calling Append once
+// per item would have produced, but does so without reading or writing a
+// storage slot for every touched partial: it carries the work for the
+// whole batch in memory, splitting it into previous-power-of-two-sized
+// chunks that can each be built bottom-up in a single pass, and flushes the
+// accumulator's final state to storage only once at the end.
+func (acc *MerkleAccumulator) AppendMany(items []common.Hash) []*EventForTreeBuilding {
+	if len(items) == 0 {
+		return nil
+	}
+
+	for level := uint64(0); level < acc.numPartials; level++ {
+		acc.getPartial(level) // load every existing partial now, so the loop below never touches storage
+	}
+	realStorage := acc.backingStorage
+	acc.backingStorage = nil
+
+	events := make([]*EventForTreeBuilding, 0, len(items))
+	for len(items) > 0 {
+		pow2 := PreviousPowerOfTwo(uint64(len(items)))
+		events = append(events, acc.appendChunk(items[:pow2])...)
+		items = items[pow2:]
+	}
+
+	acc.backingStorage = realStorage
+	if acc.backingStorage != nil {
+		acc.flush()
+	}
+	return events
+}
+
+// appendChunk folds a chunk whose length is a power of two into acc. If
+// acc's levels below the chunk's own top level are all currently empty (the
+// common case for a fresh or batch-aligned accumulator), it builds the
+// chunk's internal hashes bottom-up in a temporary pyramid and derives each
+// item's settling level from the binary carry sequence, touching partials
+// only once to fold in the chunk's combined hash. Otherwise it falls back
+// to combining the chunk into the accumulator one item at a time.
+func (acc *MerkleAccumulator) appendChunk(chunk []common.Hash) []*EventForTreeBuilding {
+	levels := uint64(0)
+	for uint64(1)<<levels < uint64(len(chunk)) {
+		levels++
+	}
+
+	for l := uint64(0); l < levels; l++ {
+		if l < acc.numPartials && *acc.getPartial(l) != (common.Hash{}) {
+			events := make([]*EventForTreeBuilding, 0, len(chunk))
+			for _, item := range chunk {
+				events = append(events, acc.Append(item)...)
+			}
+			return events
+		}
+	}
+
+	pyramid := make([][]common.Hash, levels+1)
+	pyramid[0] = make([]common.Hash, len(chunk))
+	for i, item := range chunk {
+		pyramid[0][i] = hashLeaf(acc.scheme, item)
+	}
+	for l := uint64(1); l <= levels; l++ {
+		prev := pyramid[l-1]
+		cur := make([]common.Hash, len(prev)/2)
+		for j := range cur {
+			cur[j] = hashInternal(acc.scheme, prev[2*j], prev[2*j+1])
+		}
+		pyramid[l] = cur
+	}
+
+	// Item i of a fresh power-of-two batch settles at the level equal to its
+	// local index's number of trailing 1 bits, the same carry-chain length a
+	// binary counter gets when incremented; its call touches every level
+	// from 0 up to that one, and each of those is a complete subtree that
+	// Append (now that it reports every level it touches, not just the
+	// final one) would have reported a separate event for.
+	leafBase := acc.size
+	var events []*EventForTreeBuilding
+	for i := range chunk {
+		settleLevel := uint64(bits.TrailingZeros64(^uint64(i)))
+		for l := uint64(0); l <= settleLevel; l++ {
+			events = append(events, &EventForTreeBuilding{l, leafBase + uint64(i), pyramid[l][uint64(i)>>l]})
+		}
+	}
+	acc.size += uint64(len(chunk))
+
+	// events already holds the level-`levels` event for the chunk's own top
+	// subtree (pyramid[levels][0], emitted above for the chunk's last item).
+	// If that level is already occupied by a pre-existing partial, folding
+	// it in cascades further just like Append's carry loop would, and each
+	// higher level it passes through is itself a complete subtree that
+	// deserves its own event -- appended here, never overwriting the one
+	// already recorded for level `levels`.
+	leafNum := leafBase + uint64(len(chunk)) - 1
+	foldEvents := acc.foldSubtreeIntoPartials(levels, pyramid[levels][0], leafNum)
+	events = append(events, foldEvents[1:]...)
+
+	return events
+}
+
+// foldSubtreeIntoPartials combines a complete subtree of 2^level leaves,
+// already hashed down to a single value, into acc's partials, the same way
+// Append's carry loop folds in a single new leaf, and returns one event for
+// every level the fold touches, in level order, starting with level itself
+// -- not just the level it finally settles at -- so that combining with an
+// already-occupied higher partial doesn't discard the hash the subtree had
+// at its own level.
+func (acc *MerkleAccumulator) foldSubtreeIntoPartials(level uint64, hash common.Hash, leafNum uint64) []*EventForTreeBuilding {
+	for acc.numPartials < level {
+		zero := common.Hash{}
+		acc.setPartial(acc.numPartials, &zero)
+	}
+	var events []*EventForTreeBuilding
+	for {
+		events = append(events, &EventForTreeBuilding{level, leafNum, hash})
+		if level == acc.numPartials {
+			acc.setPartial(level, &hash)
+			return events
+		}
+		thisLevel := acc.getPartial(level)
+		if *thisLevel == (common.Hash{}) {
+			acc.setPartial(level, &hash)
+			return events
+		}
+		hash = hashInternal(acc.scheme, *thisLevel, hash)
+		zero := common.Hash{}
+		acc.setPartial(level, &zero)
+		level++
+	}
+}
+
+// flush writes acc's in-memory state to storage, touching each metadata
+// and partials slot exactly once regardless of how many items were folded
+// in since the last flush.
+func (acc *MerkleAccumulator) flush() {
+	acc.backingStorage.SetByInt64(0, encodeSizeAndScheme(acc.size, acc.scheme))
+	acc.backingStorage.SetByInt64(1, util.IntToHash(int64(acc.numPartials)))
+	for level, partial := range acc.partials {
+		if partial != nil {
+			acc.backingStorage.SetByInt64(int64(2+level), *partial)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// HashScheme selects how a MerkleAccumulator turns leaves and pairs of
+// subtree hashes into node hashes. It is persisted alongside an
+// accumulator's size so that re-opening it later, possibly after a binary
+// upgrade that changes the compile-time default, always reproduces the same
+// root.
+type HashScheme uint8
+
+const (
+	// LegacyHashScheme hashes a leaf as the raw item hash and an internal
+	// node as bare keccak256(left || right), with no domain separation
+	// between the two. This is the accumulator's original behavior and
+	// remains the default: an internal node hash of the right shape is
+	// indistinguishable from a leaf, but existing chains depend on it, so
+	// it cannot change underfoot.
+	LegacyHashScheme HashScheme = iota
+
+	// RFC6962HashScheme domain-separates leaves and internal nodes the way
+	// RFC 6962 (and CometBFT/SEDA's Merkle trees) do: a leaf is hashed as
+	// H(0x00 || item) and an internal node as H(0x01 || left || right), so
+	// neither can be mistaken for the other.
+	RFC6962HashScheme
+)
+
+// DefaultHashScheme is the scheme used by NewNonpersistentMerkleAccumulator
+// and NewNonPersistentMerkleAccumulatorFromEvents. OpenMerkleAccumulator
+// does not use it: it takes the scheme stored in slot 0, which reads as
+// LegacyHashScheme for storage that was never tagged. It is a
+// compile-time constant, not a runtime setting, precisely so that existing
+// deployments keep the legacy hashing they were built on; new deployments
+// that want domain separation must opt in explicitly via
+// OpenMerkleAccumulatorWithScheme.
+const DefaultHashScheme = LegacyHashScheme
+
+func hashLeaf(scheme HashScheme, item common.Hash) common.Hash {
+	if scheme == RFC6962HashScheme {
+		return crypto.Keccak256Hash([]byte{0x00}, item.Bytes())
+	}
+	return item
+}
+
+func hashInternal(scheme HashScheme, left, right common.Hash) common.Hash {
+	if scheme == RFC6962HashScheme {
+		return crypto.Keccak256Hash([]byte{0x01}, left.Bytes(), right.Bytes())
+	}
+	return crypto.Keccak256Hash(left.Bytes(), right.Bytes())
+}
+
+// encodeSizeAndScheme packs size and scheme into slot 0's 256 bits: size in the
+// low 64 bits, matching the original layout, and scheme in the next byte up.
+// Bits above that are reserved for future metadata.
+func encodeSizeAndScheme(size uint64, scheme HashScheme) common.Hash {
+	packed := new(big.Int).Lsh(big.NewInt(int64(scheme)), 64)
+	packed.Or(packed, new(big.Int).SetUint64(size))
+	return common.BigToHash(packed)
+}
+
+func decodeSizeAndScheme(slot common.Hash) (uint64, HashScheme) {
+	packed := slot.Big()
+	sizeMask := new(big.Int).SetUint64(^uint64(0))
+	size := new(big.Int).And(packed, sizeMask).Uint64()
+	scheme := HashScheme(new(big.Int).Rsh(packed, 64).Uint64())
+	return size, scheme
+}
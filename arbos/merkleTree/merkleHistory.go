@@ -0,0 +1,40 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// RootAt returns the root the accumulator would have reported when it had
+// exactly size leaves, by replaying events to recover the hashes of
+// subtrees that have since been folded away or cleared. events must
+// contain every EventForTreeBuilding that Append or AppendMany has ever
+// emitted for this accumulator, in order.
+func (acc *MerkleAccumulator) RootAt(size uint64, events []EventForTreeBuilding) (common.Hash, error) {
+	return RootAtSize(size, acc.scheme, events)
+}
+
+// RootAtSize is the scheme-agnostic form of RootAt, for recovering a
+// historical root from a bare event log without holding a live
+// MerkleAccumulator.
+func RootAtSize(size uint64, scheme HashScheme, events []EventForTreeBuilding) (common.Hash, error) {
+	if size == 0 {
+		return common.Hash{}, nil
+	}
+	blocks := occupiedBlocks(size)
+	index := buildSubtreeIndex(events)
+	return foldHashUpTo(index, scheme, blocks, len(blocks)-1)
+}
+
+// SubtreeRoot returns the hash of the complete subtree of 2^level leaves
+// starting at leaf index<<level, as recovered by replaying events, along
+// with whether the event log covers it. level and index address a subtree
+// the same way an occupied block of Root's fold does: level is its height
+// and index is its position among all subtrees of that height, counting
+// from the start of the tree.
+func SubtreeRoot(level, index uint64, events []EventForTreeBuilding) (common.Hash, bool) {
+	return buildSubtreeIndex(events).lookup(level, index<<level)
+}
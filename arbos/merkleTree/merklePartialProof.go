@@ -0,0 +1,270 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PartialMerkleProof is a compact, SPV-style proof that a chosen subset of
+// an accumulator's leaves is included in a single root, modeled on the
+// partial Merkle tree encoding Bitcoin and Elements use for merkleblock
+// messages. Flags records a depth-first traversal of the tree Root folds
+// together: true means "this subtree contains a requested leaf, descend
+// into it"; false means "take the next entry in Hashes as this subtree's
+// hash and go no further". Batching many leaves against one root this way
+// is far cheaper than N independent single-leaf proofs.
+type PartialMerkleProof struct {
+	Size   uint64
+	Scheme HashScheme
+	Flags  []bool
+	Hashes []common.Hash
+}
+
+type merkleBlock struct {
+	level uint64
+	start uint64
+}
+
+// occupiedBlocks returns, in ascending level order (the same order Root
+// folds them together in), the complete subtrees a tree of the given size
+// is built from. These correspond exactly to the set bits of size.
+func occupiedBlocks(size uint64) []merkleBlock {
+	var blocks []merkleBlock
+	for l := uint64(0); (uint64(1) << l) <= size; l++ {
+		if size&(uint64(1)<<l) != 0 {
+			blocks = append(blocks, merkleBlock{l, blockStartForLevel(l, size)})
+		}
+	}
+	return blocks
+}
+
+// capacityInHashAfter returns the capacityInHash value Root's fold
+// algorithm holds immediately after folding in blocks[upTo], so that the
+// padding needed before the next block can be computed without replaying
+// the whole fold from the start.
+func capacityInHashAfter(blocks []merkleBlock, upTo int) uint64 {
+	if upTo == 0 {
+		return uint64(1) << blocks[0].level
+	}
+	return uint64(1) << (blocks[upTo].level + 1)
+}
+
+func padTo(scheme HashScheme, h common.Hash, fromCapacity, toCapacity uint64) common.Hash {
+	for c := fromCapacity; c < toCapacity; c *= 2 {
+		h = hashInternal(scheme, h, common.Hash{})
+	}
+	return h
+}
+
+func rangeHasMatch(lo, hi uint64, wanted map[uint64]bool) bool {
+	for index := range wanted {
+		if index >= lo && index < hi {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildPartialProof builds a PartialMerkleProof revealing the leaves at
+// indices against the accumulator's current root. events must contain
+// every EventForTreeBuilding the accumulator (or an AppendMany batch) has
+// ever emitted, in order, so that the hashes of subtrees folded away long
+// ago can be recovered.
+func (acc *MerkleAccumulator) BuildPartialProof(indices []uint64, events []EventForTreeBuilding) (*PartialMerkleProof, error) {
+	return BuildPartialProofAtSize(indices, acc.size, acc.scheme, events)
+}
+
+// BuildPartialProofAtSize is the size- and scheme-agnostic form of
+// BuildPartialProof, for proving against a historical root such as one
+// returned by RootAt.
+func BuildPartialProofAtSize(indices []uint64, size uint64, scheme HashScheme, events []EventForTreeBuilding) (*PartialMerkleProof, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("cannot build a partial proof for an empty tree")
+	}
+	wanted := make(map[uint64]bool, len(indices))
+	for _, leafIndex := range indices {
+		if leafIndex >= size {
+			return nil, fmt.Errorf("leaf index %v out of range for tree of size %v", leafIndex, size)
+		}
+		wanted[leafIndex] = true
+	}
+
+	blocks := occupiedBlocks(size)
+	index := buildSubtreeIndex(events)
+	proof := &PartialMerkleProof{Size: size, Scheme: scheme}
+	if _, err := proof.emitTree(index, blocks, len(blocks)-1, wanted); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+func (proof *PartialMerkleProof) emitTree(index subtreeIndex, blocks []merkleBlock, upTo int, wanted map[uint64]bool) (common.Hash, error) {
+	b := blocks[upTo]
+	if upTo == 0 {
+		return proof.emitBlock(index, b.level, b.start, wanted)
+	}
+
+	capacity := uint64(1) << b.level
+	// blocks[0..upTo] together cover the leaves [b.start, Size).
+	match := rangeHasMatch(b.start, proof.Size, wanted)
+	proof.Flags = append(proof.Flags, match)
+	if !match {
+		h, err := foldHashUpTo(index, proof.Scheme, blocks, upTo)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		proof.Hashes = append(proof.Hashes, h)
+		return h, nil
+	}
+
+	leftHash, err := proof.emitBlock(index, b.level, b.start, wanted)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	rightHash, err := proof.emitTree(index, blocks, upTo-1, wanted)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	padded := padTo(proof.Scheme, rightHash, capacityInHashAfter(blocks, upTo-1), capacity)
+	return hashInternal(proof.Scheme, leftHash, padded), nil
+}
+
+func (proof *PartialMerkleProof) emitBlock(index subtreeIndex, level, start uint64, wanted map[uint64]bool) (common.Hash, error) {
+	capacity := uint64(1) << level
+	match := rangeHasMatch(start, start+capacity, wanted)
+	proof.Flags = append(proof.Flags, match)
+	if level == 0 || !match {
+		h, ok := index.lookup(level, start)
+		if !ok {
+			return common.Hash{}, fmt.Errorf("event log does not cover the subtree at level %v starting at leaf %v", level, start)
+		}
+		proof.Hashes = append(proof.Hashes, h)
+		return h, nil
+	}
+	half := capacity / 2
+	leftHash, err := proof.emitBlock(index, level-1, start, wanted)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	rightHash, err := proof.emitBlock(index, level-1, start+half, wanted)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return hashInternal(proof.Scheme, leftHash, rightHash), nil
+}
+
+// foldHashUpTo computes the hash Root's fold would have after combining
+// blocks[0..upTo], using only each block's own (already-settled) hash. It
+// lets BuildPartialProof summarize an unmatched portion of the fold chain
+// as a single hash without recursing into it.
+func foldHashUpTo(index subtreeIndex, scheme HashScheme, blocks []merkleBlock, upTo int) (common.Hash, error) {
+	hashSoFar, ok := index.lookup(blocks[0].level, blocks[0].start)
+	if !ok {
+		return common.Hash{}, fmt.Errorf("event log does not cover the subtree at level %v starting at leaf %v", blocks[0].level, blocks[0].start)
+	}
+	for i := 1; i <= upTo; i++ {
+		h, ok := index.lookup(blocks[i].level, blocks[i].start)
+		if !ok {
+			return common.Hash{}, fmt.Errorf("event log does not cover the subtree at level %v starting at leaf %v", blocks[i].level, blocks[i].start)
+		}
+		hashSoFar = padTo(scheme, hashSoFar, capacityInHashAfter(blocks, i-1), uint64(1)<<blocks[i].level)
+		hashSoFar = hashInternal(scheme, h, hashSoFar)
+	}
+	return hashSoFar, nil
+}
+
+// VerifyAndExtract checks proof against root and, if it's valid, returns the
+// indices and hashes of the leaves it revealed. The returned slices are in
+// ascending index order.
+func (proof *PartialMerkleProof) VerifyAndExtract(root common.Hash) (matchedIndices []uint64, matchedHashes []common.Hash, err error) {
+	if proof.Size == 0 {
+		return nil, nil, fmt.Errorf("proof is for an empty tree")
+	}
+	blocks := occupiedBlocks(proof.Size)
+	if len(blocks) == 0 {
+		return nil, nil, fmt.Errorf("proof is for an empty tree")
+	}
+
+	flagPos, hashPos := 0, 0
+	computedRoot, err := proof.readTree(blocks, len(blocks)-1, &flagPos, &hashPos, &matchedIndices, &matchedHashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if flagPos != len(proof.Flags) || hashPos != len(proof.Hashes) {
+		return nil, nil, fmt.Errorf("proof contains unconsumed data")
+	}
+	if computedRoot != root {
+		return nil, nil, fmt.Errorf("proof does not authenticate the given root")
+	}
+	return matchedIndices, matchedHashes, nil
+}
+
+func (proof *PartialMerkleProof) readTree(blocks []merkleBlock, upTo int, flagPos, hashPos *int, matchedIndices *[]uint64, matchedHashes *[]common.Hash) (common.Hash, error) {
+	b := blocks[upTo]
+	if upTo == 0 {
+		return proof.readBlock(b.level, b.start, flagPos, hashPos, matchedIndices, matchedHashes)
+	}
+
+	if *flagPos >= len(proof.Flags) {
+		return common.Hash{}, fmt.Errorf("proof ran out of flags")
+	}
+	match := proof.Flags[*flagPos]
+	*flagPos++
+	if !match {
+		if *hashPos >= len(proof.Hashes) {
+			return common.Hash{}, fmt.Errorf("proof ran out of hashes")
+		}
+		h := proof.Hashes[*hashPos]
+		*hashPos++
+		return h, nil
+	}
+
+	leftHash, err := proof.readBlock(b.level, b.start, flagPos, hashPos, matchedIndices, matchedHashes)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	rightHash, err := proof.readTree(blocks, upTo-1, flagPos, hashPos, matchedIndices, matchedHashes)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	capacity := uint64(1) << b.level
+	padded := padTo(proof.Scheme, rightHash, capacityInHashAfter(blocks, upTo-1), capacity)
+	return hashInternal(proof.Scheme, leftHash, padded), nil
+}
+
+func (proof *PartialMerkleProof) readBlock(level, start uint64, flagPos, hashPos *int, matchedIndices *[]uint64, matchedHashes *[]common.Hash) (common.Hash, error) {
+	if *flagPos >= len(proof.Flags) {
+		return common.Hash{}, fmt.Errorf("proof ran out of flags")
+	}
+	match := proof.Flags[*flagPos]
+	*flagPos++
+
+	if level == 0 || !match {
+		if *hashPos >= len(proof.Hashes) {
+			return common.Hash{}, fmt.Errorf("proof ran out of hashes")
+		}
+		h := proof.Hashes[*hashPos]
+		*hashPos++
+		if level == 0 && match {
+			*matchedIndices = append(*matchedIndices, start)
+			*matchedHashes = append(*matchedHashes, h)
+		}
+		return h, nil
+	}
+
+	half := uint64(1) << (level - 1)
+	leftHash, err := proof.readBlock(level-1, start, flagPos, hashPos, matchedIndices, matchedHashes)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	rightHash, err := proof.readBlock(level-1, start+half, flagPos, hashPos, matchedIndices, matchedHashes)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return hashInternal(proof.Scheme, leftHash, rightHash), nil
+}
@@ -0,0 +1,220 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// subtreeIndex maps (level, leafStart) to the hash of the complete subtree of
+// 2^level leaves beginning at leafStart, as recovered from a stream of
+// EventForTreeBuilding values. Each Append call reports only the subtrees
+// its own carry completes, so reconstructing an arbitrary sibling hash
+// requires replaying every event the accumulator has ever produced, not just
+// the latest one.
+type subtreeIndex map[uint64]map[uint64]common.Hash
+
+func buildSubtreeIndex(events []EventForTreeBuilding) subtreeIndex {
+	index := make(subtreeIndex)
+	for _, event := range events {
+		start := event.leafNum + 1 - (uint64(1) << event.level)
+		byStart, ok := index[event.level]
+		if !ok {
+			byStart = make(map[uint64]common.Hash)
+			index[event.level] = byStart
+		}
+		byStart[start] = event.hash
+	}
+	return index
+}
+
+func (index subtreeIndex) lookup(level, start uint64) (common.Hash, bool) {
+	byStart, ok := index[level]
+	if !ok {
+		return common.Hash{}, false
+	}
+	h, ok := byStart[start]
+	return h, ok
+}
+
+// blockStartForLevel returns the first leaf index of the complete subtree
+// that Root's zero-padded fold treats as occupying the given level, for a
+// tree of the given size. These blocks partition [0, size) and correspond
+// exactly to the set bits of size.
+func blockStartForLevel(level, size uint64) uint64 {
+	mask := (uint64(1) << (level + 1)) - 1
+	return size &^ mask
+}
+
+// containingBlock returns the level and starting leaf index of the unique
+// complete subtree, among those Root folds together, that contains
+// leafIndex.
+func containingBlock(leafIndex, size uint64) (level uint64, start uint64, err error) {
+	for l := uint64(0); (uint64(1) << l) <= size; l++ {
+		if size&(uint64(1)<<l) == 0 {
+			continue
+		}
+		s := blockStartForLevel(l, size)
+		if leafIndex >= s && leafIndex < s+(uint64(1)<<l) {
+			return l, s, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("leaf index %v not found in tree of size %v", leafIndex, size)
+}
+
+// ProveMembership returns the sibling hashes along the Merkle path from the
+// leaf at leafIndex up to the accumulator's current root, so that a caller
+// who only holds O(log N) partials can still prove inclusion to a light
+// client or outbox-style consumer. events must contain every
+// EventForTreeBuilding that Append or AppendMany has ever emitted for this
+// accumulator, in the order they were produced; ProveMembership replays that
+// stream to recover the sibling hashes of subtrees that were completed and
+// folded away long ago, rather than requiring the whole tree to be kept in
+// storage.
+func (acc *MerkleAccumulator) ProveMembership(leafIndex uint64, events []EventForTreeBuilding) ([]common.Hash, error) {
+	return ProveMembershipAtSize(leafIndex, acc.size, events)
+}
+
+// Scheme reports the HashScheme this accumulator was opened with, for
+// callers verifying a proof against it.
+func (acc *MerkleAccumulator) Scheme() HashScheme {
+	return acc.scheme
+}
+
+// ProveMembershipAtSize is the size-agnostic form of ProveMembership. It is
+// useful for proving membership against a historical root, such as one
+// returned by RootAt, rather than the accumulator's current size.
+func ProveMembershipAtSize(leafIndex, size uint64, events []EventForTreeBuilding) ([]common.Hash, error) {
+	if size == 0 || leafIndex >= size {
+		return nil, fmt.Errorf("leaf index %v out of range for tree of size %v", leafIndex, size)
+	}
+	index := buildSubtreeIndex(events)
+
+	blockLevel, blockStart, err := containingBlock(leafIndex, size)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := descendWithinBlock(index, blockLevel, blockStart, leafIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	outer, err := outerSiblingHashes(index, blockLevel, size)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, outer...), nil
+}
+
+// descendWithinBlock walks down from the root of a complete 2^level-leaf
+// subtree to the leaf at leafIndex, collecting the sibling hash at every
+// level along the way, ordered from the leaf upward.
+func descendWithinBlock(index subtreeIndex, level, start, leafIndex uint64) ([]common.Hash, error) {
+	if level == 0 {
+		return []common.Hash{}, nil
+	}
+	half := uint64(1) << (level - 1)
+	mid := start + half
+	var nextStart, siblingStart uint64
+	if leafIndex < mid {
+		nextStart, siblingStart = start, mid
+	} else {
+		nextStart, siblingStart = mid, start
+	}
+	sibling, ok := index.lookup(level-1, siblingStart)
+	if !ok {
+		return nil, fmt.Errorf("event log does not cover the subtree at level %v starting at leaf %v", level-1, siblingStart)
+	}
+	rest, err := descendWithinBlock(index, level-1, nextStart, leafIndex)
+	if err != nil {
+		return nil, err
+	}
+	return append(rest, sibling), nil
+}
+
+// outerSiblingHashes returns, in the same low-to-high order that Root walks
+// the occupied levels, the hash of every complete subtree other than the one
+// at targetLevel. VerifyMembership consumes these one at a time, re-deriving
+// the zero-padding Root would have applied along the way, to fold the
+// target's block hash back up into the full root.
+func outerSiblingHashes(index subtreeIndex, targetLevel, size uint64) ([]common.Hash, error) {
+	var hashes []common.Hash
+	for level := uint64(0); (uint64(1) << level) <= size; level++ {
+		if size&(uint64(1)<<level) == 0 || level == targetLevel {
+			continue
+		}
+		h, ok := index.lookup(level, blockStartForLevel(level, size))
+		if !ok {
+			return nil, fmt.Errorf("event log does not cover the subtree at level %v", level)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// VerifyMembership reports whether proof demonstrates that leaf is the item
+// at the given index in a tree of the given size whose root is root, hashed
+// under scheme. The proof format matches what ProveMembership returns:
+// sibling hashes within leaf's containing block, followed by the hash of
+// every other complete subtree Root folds in, both in leaf-to-root order.
+// Zero-padding for empty right subtrees is re-derived locally, using the
+// same rule Root uses, so it need not be carried in the proof.
+func VerifyMembership(leaf common.Hash, index, size uint64, root common.Hash, proof []common.Hash, scheme HashScheme) bool {
+	if size == 0 || index >= size {
+		return false
+	}
+	blockLevel, _, err := containingBlock(index, size)
+	if err != nil || uint64(len(proof)) < blockLevel {
+		return false
+	}
+
+	current := hashLeaf(scheme, leaf)
+	for l := uint64(0); l < blockLevel; l++ {
+		sibling := proof[l]
+		if (index>>l)&1 == 0 {
+			current = hashInternal(scheme, current, sibling)
+		} else {
+			current = hashInternal(scheme, sibling, current)
+		}
+	}
+	outer := proof[blockLevel:]
+
+	var hashSoFar *common.Hash
+	var capacityInHash uint64
+	outerPos := 0
+	capacity := uint64(1)
+	for level := uint64(0); capacity <= size; level++ {
+		if size&(uint64(1)<<level) != 0 {
+			var partial common.Hash
+			if level == blockLevel {
+				partial = current
+			} else {
+				if outerPos >= len(outer) {
+					return false
+				}
+				partial = outer[outerPos]
+				outerPos++
+			}
+			if hashSoFar == nil {
+				hashSoFar = &partial
+				capacityInHash = capacity
+			} else {
+				for capacityInHash < capacity {
+					h := hashInternal(scheme, *hashSoFar, common.Hash{})
+					hashSoFar = &h
+					capacityInHash *= 2
+				}
+				h := hashInternal(scheme, partial, *hashSoFar)
+				hashSoFar = &h
+				capacityInHash = 2 * capacity
+			}
+		}
+		capacity *= 2
+	}
+	return outerPos == len(outer) && hashSoFar != nil && *hashSoFar == root
+}
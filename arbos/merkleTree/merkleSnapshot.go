@@ -0,0 +1,103 @@
+//
+// Copyright 2021-2022, Offchain Labs, Inc. All rights reserved.
+//
+
+package merkleTree
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/offchainlabs/arbstate/arbos/storage"
+	"github.com/offchainlabs/arbstate/arbos/util"
+)
+
+// snapshotVersion1 is the only snapshot format so far: a version byte, a
+// scheme byte, size and numPartials as big-endian uint64s, and then
+// numPartials partial hashes in level order. Future formats should bump
+// this and keep decodeSnapshot able to read the old one.
+const snapshotVersion1 = 1
+
+// Snapshot encodes acc's full state -- size, hash scheme, and every
+// partial -- into a compact, self-describing byte slice, for checkpointing
+// an accumulator somewhere cheaper than per-slot storage, such as a batch
+// receipt or a cross-chain message. LoadMerkleAccumulator reconstructs an
+// accumulator from the result, and Verify checks one against an expected
+// root without needing any storage at all.
+func (acc *MerkleAccumulator) Snapshot() ([]byte, error) {
+	partials := make([]common.Hash, acc.numPartials)
+	for level := uint64(0); level < acc.numPartials; level++ {
+		partials[level] = *acc.getPartial(level)
+	}
+	return encodeSnapshot(acc.size, acc.scheme, partials), nil
+}
+
+func encodeSnapshot(size uint64, scheme HashScheme, partials []common.Hash) []byte {
+	buf := make([]byte, 18+32*len(partials))
+	buf[0] = snapshotVersion1
+	buf[1] = byte(scheme)
+	binary.BigEndian.PutUint64(buf[2:10], size)
+	binary.BigEndian.PutUint64(buf[10:18], uint64(len(partials)))
+	for i, partial := range partials {
+		copy(buf[18+32*i:18+32*(i+1)], partial.Bytes())
+	}
+	return buf
+}
+
+func decodeSnapshot(snap []byte) (size uint64, scheme HashScheme, partials []common.Hash, err error) {
+	if len(snap) < 18 {
+		return 0, 0, nil, fmt.Errorf("merkle accumulator snapshot is too short")
+	}
+	if snap[0] != snapshotVersion1 {
+		return 0, 0, nil, fmt.Errorf("unrecognized merkle accumulator snapshot version %v", snap[0])
+	}
+	scheme = HashScheme(snap[1])
+	size = binary.BigEndian.Uint64(snap[2:10])
+	numPartials := binary.BigEndian.Uint64(snap[10:18])
+	if uint64(len(snap)) != 18+32*numPartials {
+		return 0, 0, nil, fmt.Errorf("merkle accumulator snapshot has wrong length for %v partials", numPartials)
+	}
+	partials = make([]common.Hash, numPartials)
+	for i := range partials {
+		partials[i] = common.BytesToHash(snap[18+32*i : 18+32*(i+1)])
+	}
+	return size, scheme, partials, nil
+}
+
+// LoadMerkleAccumulator restores the accumulator encoded in snap into sto,
+// overwriting whatever accumulator state, if any, was already there, and
+// returns the restored accumulator opened against sto.
+func LoadMerkleAccumulator(sto *storage.Storage, snap []byte) (*MerkleAccumulator, error) {
+	size, scheme, partials, err := decodeSnapshot(snap)
+	if err != nil {
+		return nil, err
+	}
+	sto.SetByInt64(0, encodeSizeAndScheme(size, scheme))
+	sto.SetByInt64(1, util.IntToHash(int64(len(partials))))
+	for level, partial := range partials {
+		sto.SetByInt64(int64(2+level), partial)
+	}
+	return OpenMerkleAccumulator(sto), nil
+}
+
+// Verify reports whether snap decodes to an accumulator whose root is
+// expectedRoot, without touching storage: it rebuilds the snapshot's state
+// in a nonpersistent MerkleAccumulator and compares that one's root.
+func Verify(snap []byte, expectedRoot common.Hash) error {
+	size, scheme, partials, err := decodeSnapshot(snap)
+	if err != nil {
+		return err
+	}
+	acc := NewNonpersistentMerkleAccumulatorWithScheme(scheme)
+	acc.size = size
+	acc.numPartials = uint64(len(partials))
+	acc.partials = make([]*common.Hash, len(partials))
+	for level := range partials {
+		acc.partials[level] = &partials[level]
+	}
+	if root := acc.Root(); root != expectedRoot {
+		return fmt.Errorf("merkle accumulator snapshot's root %v does not match expected root %v", root, expectedRoot)
+	}
+	return nil
+}